@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	maxRequestsInFlight  = flag.Int("max-requests-in-flight", 0, "Maximum number of non-long-running requests handled concurrently before the server rejects new ones with 429. 0 disables the limit.")
+	longRunningRequestRE = flag.String("long-running-request-re", "^$", "Regexp matching request paths exempt from --max-requests-in-flight.")
+)
+
+// maxInFlightLimiter bounds the number of concurrent requests not matched by longRunning,
+// following the pattern kube-apiserver uses for its own admission control.
+type maxInFlightLimiter struct {
+	sem         chan struct{}
+	longRunning *regexp.Regexp
+}
+
+// newMaxInFlightLimiter builds a maxInFlightLimiter with the given ceiling and long-running
+// exemption regexp. A non-positive max disables admission control entirely.
+func newMaxInFlightLimiter(max int, longRunningRE string) (*maxInFlightLimiter, error) {
+	re, err := regexp.Compile(longRunningRE)
+	if err != nil {
+		return nil, err
+	}
+
+	var sem chan struct{}
+	if max > 0 {
+		sem = make(chan struct{}, max)
+	}
+	return &maxInFlightLimiter{sem: sem, longRunning: re}, nil
+}
+
+// WithMaxInFlight wraps h, rejecting non-long-running requests over the configured ceiling
+// with 429 instead of queueing them indefinitely.
+func (l *maxInFlightLimiter) WithMaxInFlight(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.sem == nil || l.longRunning.MatchString(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+			defer func() { <-l.sem }()
+			h.ServeHTTP(w, r)
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+}
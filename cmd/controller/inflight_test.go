@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestMaxInFlightLimiterRejectsOverCeiling(t *testing.T) {
+	block := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(1)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-block
+		w.WriteHeader(http.StatusOK)
+	})
+
+	l, err := newMaxInFlightLimiter(1, "^$")
+	if err != nil {
+		t.Fatalf("newMaxInFlightLimiter: %v", err)
+	}
+	wrapped := l.WithMaxInFlight(h)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/verify", nil))
+	}()
+	started.Wait()
+
+	rec := httptest.NewRecorder()
+	wrapped.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/verify", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second concurrent request got %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	close(block)
+	wg.Wait()
+}
+
+func TestMaxInFlightLimiterExemptsLongRunningPaths(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	l, err := newMaxInFlightLimiter(1, "^/v1/rotate$")
+	if err != nil {
+		t.Fatalf("newMaxInFlightLimiter: %v", err)
+	}
+	wrapped := l.WithMaxInFlight(h)
+
+	// An exempt path bypasses the semaphore entirely, so it never blocks on itself even when
+	// the ceiling is already saturated by an identical in-flight request.
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+
+	rec := httptest.NewRecorder()
+	wrapped.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/rotate", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("exempt path got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMaxInFlightLimiterDisabledByZeroMax(t *testing.T) {
+	l, err := newMaxInFlightLimiter(0, "^$")
+	if err != nil {
+		t.Fatalf("newMaxInFlightLimiter: %v", err)
+	}
+	wrapped := l.WithMaxInFlight(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	wrapped.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/verify", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("max=0 should disable admission control, got %d", rec.Code)
+	}
+}
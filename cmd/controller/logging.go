@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+var requestLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// newRequestID returns a random 20-character base32 request ID, following the pattern Arvados
+// uses to correlate a single request across logs.
+func newRequestID() string {
+	var buf [13]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return ""
+	}
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf[:])
+	return strings.ToLower(enc)[:20]
+}
+
+// requestIDFromContext returns the request ID stashed on ctx by withRequestLogging, or "" if
+// none is present (e.g. an RPC call made outside of an HTTP request).
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// statusRecorder captures the status code and byte count written through an
+// http.ResponseWriter so withRequestLogging can log them after the handler returns.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.written += int64(n)
+	return n, err
+}
+
+// withRequestLogging assigns each inbound request a request ID, echoes it in the
+// X-Request-Id response header, stashes it on the request context, and emits one JSON log
+// line per request once the handler returns.
+func withRequestLogging(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID()
+		w.Header().Set("X-Request-Id", id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+
+		requestLogger.Info("http request",
+			"requestID", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"durationSeconds", time.Since(start).Seconds(),
+			"remoteAddr", r.RemoteAddr,
+			"forwardedFor", r.Header.Get("X-Forwarded-For"),
+			"bytesWritten", rec.written,
+		)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	flag "github.com/spf13/pflag"
+)
+
+// version is overridden at build time via -ldflags.
+var version = "unknown"
+
+var metricsListenAddr = flag.String("metrics-listen-addr", "", "Address to serve Prometheus metrics on. Empty serves them on --listen-addr alongside the public API.")
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealedsecrets_http_requests_total",
+		Help: "Total number of HTTP requests handled, by path and status code.",
+	}, []string{"path", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sealedsecrets_http_request_duration_seconds",
+		Help: "HTTP request handling duration in seconds, by path.",
+	}, []string{"path"})
+
+	verifyValidTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sealedsecrets_verify_valid_total",
+		Help: "Total number of /v1/verify requests that found a valid secret.",
+	})
+
+	verifyInvalidTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sealedsecrets_verify_invalid_total",
+		Help: "Total number of /v1/verify requests that found an invalid secret.",
+	})
+
+	rotateErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sealedsecrets_rotate_errors_total",
+		Help: "Total number of /v1/rotate requests that failed.",
+	})
+
+	ratelimitRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealedsecrets_ratelimit_rejections_total",
+		Help: "Total number of requests rejected by the rate limiter, by path.",
+	}, []string{"path"})
+
+	certsServedBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sealedsecrets_certs_served_bytes",
+		Help: "Size in bytes of the certificate chain most recently served from /v1/cert.pem, by key name.",
+	}, []string{"keyname"})
+
+	activeKeyname = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sealedsecrets_active_keyname",
+		Help: "1 for the currently active signing key name; no series is kept for key names previously reported active.",
+	}, []string{"keyname"})
+
+	rpcRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sealedsecrets_rpc_requests_total",
+		Help: "Total number of RPC calls handled, by method.",
+	}, []string{"method"})
+
+	rpcRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sealedsecrets_rpc_request_duration_seconds",
+		Help: "RPC call handling duration in seconds, by method.",
+	}, []string{"method"})
+
+	buildInfoGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sealedsecrets_build_info",
+		Help: "Constant 1-valued metric labeled by version and Go runtime version.",
+	}, []string{"version", "goVersion"})
+)
+
+func init() {
+	buildInfoGauge.WithLabelValues(version, runtime.Version()).Set(1)
+}
+
+// setActiveKeyname reports keyname as the sole active signing key, removing the series of
+// whichever key name was previously reported active.
+func setActiveKeyname(keyname string) {
+	activeKeyname.Reset()
+	activeKeyname.WithLabelValues(keyname).Set(1)
+}
+
+// withMetrics records request count (by status code) and duration for path.
+func withMetrics(path string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(rec, r)
+
+		httpRequestDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+		httpRequestsTotal.WithLabelValues(path, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// serveMetrics starts a dedicated HTTP server for /metrics on addr. It's used when
+// --metrics-listen-addr differs from --listen-addr, so operators can keep metrics off the
+// public API port.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("Metrics server serving on %s", addr)
+	log.Fatalf("Metrics server exiting: %v", http.ListenAndServe(addr, mux))
+}
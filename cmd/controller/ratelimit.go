@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"github.com/throttled/throttled"
+	"github.com/throttled/throttled/store/memstore"
+	"gopkg.in/yaml.v2"
+)
+
+// EndpointRateLimit overrides the server-wide rate limit quota for a single /v1/* path.
+type EndpointRateLimit struct {
+	Path     string `yaml:"path"`
+	PerSec   int    `yaml:"perSec"`
+	PerMin   int    `yaml:"perMin"`
+	PerHour  int    `yaml:"perHour"`
+	MaxBurst int    `yaml:"maxBurst"`
+}
+
+// RateLimitConfig controls the GCRA token-bucket rate limiter applied to the /v1/* handlers.
+type RateLimitConfig struct {
+	PerSec            int                 `yaml:"perSec"`
+	PerMin            int                 `yaml:"perMin"`
+	PerHour           int                 `yaml:"perHour"`
+	MaxBurst          int                 `yaml:"maxBurst"`
+	MemoryStoreSize   int                 `yaml:"memoryStoreSize"`
+	VaryByPath        bool                `yaml:"varyByPath"`
+	VaryByRemoteAddr  bool                `yaml:"varyByRemoteAddr"`
+	VaryByHeader      []string            `yaml:"varyByHeader"`
+	EndpointOverrides []EndpointRateLimit `yaml:"endpointOverrides"`
+}
+
+var (
+	rateLimitPerSec           = flag.Int("rate-limit-per-sec", 2, "Requests per second allowed per client for /v1/* endpoints. 0 disables the per-second limit; if --rate-limit-per-min and --rate-limit-per-hour are also 0, rate limiting is disabled entirely.")
+	rateLimitPerMin           = flag.Int("rate-limit-per-min", 0, "Requests per minute allowed per client for /v1/* endpoints, used when --rate-limit-per-sec is 0.")
+	rateLimitPerHour          = flag.Int("rate-limit-per-hour", 0, "Requests per hour allowed per client for /v1/* endpoints, used when --rate-limit-per-sec and --rate-limit-per-min are 0.")
+	rateLimitMaxBurst         = flag.Int("rate-limit-max-burst", 2, "Maximum burst size allowed by the rate limiter.")
+	rateLimitMemoryStoreSize  = flag.Int("rate-limit-memory-store-size", 65536, "Number of distinct rate limit keys tracked in memory.")
+	rateLimitVaryByPath       = flag.Bool("rate-limit-vary-by-path", true, "Vary the rate limit key by request path.")
+	rateLimitVaryByRemoteAddr = flag.Bool("rate-limit-vary-by-remote-addr", true, "Vary the rate limit key by the client's remote address / X-Forwarded-For.")
+	rateLimitVaryByHeader     = flag.StringSlice("rate-limit-vary-by-header", []string{"X-Forwarded-For"}, "Additional request headers to vary the rate limit key by.")
+	rateLimitConfigFile       = flag.String("rate-limit-config-file", "", "Path to a YAML file overriding the rate limit configuration.")
+)
+
+// defaultRateLimitConfig builds a RateLimitConfig from the registered pflag values.
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		PerSec:           *rateLimitPerSec,
+		PerMin:           *rateLimitPerMin,
+		PerHour:          *rateLimitPerHour,
+		MaxBurst:         *rateLimitMaxBurst,
+		MemoryStoreSize:  *rateLimitMemoryStoreSize,
+		VaryByPath:       *rateLimitVaryByPath,
+		VaryByRemoteAddr: *rateLimitVaryByRemoteAddr,
+		VaryByHeader:     *rateLimitVaryByHeader,
+	}
+}
+
+// loadRateLimitConfig returns the flag-derived RateLimitConfig, overlaid with the contents of
+// *rateLimitConfigFile when one is set.
+func loadRateLimitConfig() (RateLimitConfig, error) {
+	cfg := defaultRateLimitConfig()
+	if *rateLimitConfigFile == "" {
+		return cfg, nil
+	}
+
+	raw, err := ioutil.ReadFile(*rateLimitConfigFile)
+	if err != nil {
+		return cfg, fmt.Errorf("reading rate limit config file: %v", err)
+	}
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing rate limit config file %s: %v", *rateLimitConfigFile, err)
+	}
+	return cfg, nil
+}
+
+// quotaFor picks the most granular rate configured on cfg, preferring PerSec, then PerMin,
+// then PerHour. ok is false when none of the three are positive, meaning rate limiting is
+// disabled entirely.
+func quotaFor(cfg RateLimitConfig) (quota throttled.RateQuota, ok bool) {
+	switch {
+	case cfg.PerSec > 0:
+		return throttled.RateQuota{MaxRate: throttled.PerSec(cfg.PerSec), MaxBurst: cfg.MaxBurst}, true
+	case cfg.PerMin > 0:
+		return throttled.RateQuota{MaxRate: throttled.PerMin(cfg.PerMin), MaxBurst: cfg.MaxBurst}, true
+	case cfg.PerHour > 0:
+		return throttled.RateQuota{MaxRate: throttled.PerHour(cfg.PerHour), MaxBurst: cfg.MaxBurst}, true
+	default:
+		return throttled.RateQuota{}, false
+	}
+}
+
+// overrideConfig merges an endpoint-specific override onto the server-wide base config.
+func overrideConfig(base RateLimitConfig, o EndpointRateLimit) RateLimitConfig {
+	merged := base
+	if o.PerSec > 0 || o.PerMin > 0 || o.PerHour > 0 {
+		merged.PerSec, merged.PerMin, merged.PerHour = o.PerSec, o.PerMin, o.PerHour
+	}
+	if o.MaxBurst > 0 {
+		merged.MaxBurst = o.MaxBurst
+	}
+	return merged
+}
+
+// NewRateLimiter builds the GCRA rate limiter described by cfg, plus one additional limiter
+// per entry in cfg.EndpointOverrides, keyed by path. The base limiter is nil when cfg.PerSec,
+// cfg.PerMin, and cfg.PerHour are all non-positive, meaning rate limiting is disabled
+// server-wide — overrides are still built for any entry in cfg.EndpointOverrides with a
+// positive rate of its own, so a per-endpoint override remains effective even then.
+func NewRateLimiter(cfg RateLimitConfig) (*throttled.GCRARateLimiter, map[string]*throttled.GCRARateLimiter, error) {
+	var limiter *throttled.GCRARateLimiter
+	if quota, ok := quotaFor(cfg); ok {
+		store, err := memstore.New(cfg.MemoryStoreSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		limiter, err = throttled.NewGCRARateLimiter(store, quota)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	overrides := make(map[string]*throttled.GCRARateLimiter, len(cfg.EndpointOverrides))
+	for _, o := range cfg.EndpointOverrides {
+		overrideQuota, ok := quotaFor(overrideConfig(cfg, o))
+		if !ok {
+			continue
+		}
+		overrideStore, err := memstore.New(cfg.MemoryStoreSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		overrideLimiter, err := throttled.NewGCRARateLimiter(overrideStore, overrideQuota)
+		if err != nil {
+			return nil, nil, err
+		}
+		overrides[o.Path] = overrideLimiter
+	}
+
+	return limiter, overrides, nil
+}
+
+// GenerateKey composes the rate limit bucket key for r out of its path, remote address, the
+// configured vary headers, and (if present) a hash of its Authorization token, following the
+// pattern Mattermost's RateLimiter uses to key per-client buckets. The token is hashed rather
+// than stored verbatim since the key lives on in the in-memory GCRA store.
+func GenerateKey(cfg RateLimitConfig, r *http.Request) string {
+	var parts []string
+
+	if cfg.VaryByPath {
+		parts = append(parts, r.URL.Path)
+	}
+
+	if cfg.VaryByRemoteAddr {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts = append(parts, strings.TrimSpace(strings.Split(fwd, ",")[0]))
+		} else {
+			parts = append(parts, r.RemoteAddr)
+		}
+	}
+
+	for _, h := range cfg.VaryByHeader {
+		parts = append(parts, r.Header.Get(h))
+	}
+
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		parts = append(parts, hex.EncodeToString(sum[:]))
+	}
+
+	return strings.Join(parts, "|")
+}
+
+// rateLimitMiddleware rejects requests that exceed cfg's quota with a 429, consulting
+// overrides for a per-endpoint limiter before falling back to limiter.
+func rateLimitMiddleware(cfg RateLimitConfig, limiter *throttled.GCRARateLimiter, overrides map[string]*throttled.GCRARateLimiter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := limiter
+		if override, ok := overrides[r.URL.Path]; ok {
+			rl = override
+		}
+		if rl == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		limited, context, err := rl.RateLimit(GenerateKey(cfg, r), 1)
+		if err != nil {
+			requestLogger.Error("error applying rate limit", "requestID", requestIDFromContext(r.Context()), "error", err)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("X-RateLimit-Limit", strconv.Itoa(context.Limit))
+		if limited {
+			ratelimitRejectionsTotal.WithLabelValues(r.URL.Path).Inc()
+			w.Header().Add("Retry-After", strconv.Itoa(int(context.RetryAfter/time.Second)))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
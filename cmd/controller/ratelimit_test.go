@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/throttled/throttled"
+)
+
+func TestGenerateKey(t *testing.T) {
+	cfg := RateLimitConfig{
+		VaryByPath:       true,
+		VaryByRemoteAddr: true,
+		VaryByHeader:     []string{"X-Custom"},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/cert.pem", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Custom", "abc")
+	r.Header.Set("Authorization", "Bearer super-secret-token")
+
+	key := GenerateKey(cfg, r)
+
+	if want := "/v1/cert.pem|10.0.0.1:1234|abc"; key[:len(want)] != want {
+		t.Fatalf("GenerateKey prefix = %q, want prefix %q", key, want)
+	}
+	if strings.Contains(key, "super-secret-token") {
+		t.Fatalf("GenerateKey leaked the raw Authorization header into the key: %q", key)
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "/v1/cert.pem", nil)
+	other.RemoteAddr = "10.0.0.1:1234"
+	other.Header.Set("X-Custom", "abc")
+	other.Header.Set("Authorization", "Bearer super-secret-token")
+	if GenerateKey(cfg, other) != key {
+		t.Fatalf("GenerateKey is not deterministic for identical requests")
+	}
+
+	other.Header.Set("Authorization", "Bearer a-different-token")
+	if GenerateKey(cfg, other) == key {
+		t.Fatalf("GenerateKey produced the same key for two different Authorization tokens")
+	}
+}
+
+func TestGenerateKeyPrefersXForwardedFor(t *testing.T) {
+	cfg := RateLimitConfig{VaryByRemoteAddr: true}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.5, 10.0.0.1")
+
+	if key := GenerateKey(cfg, r); key != "203.0.113.5" {
+		t.Fatalf("GenerateKey = %q, want the first X-Forwarded-For hop", key)
+	}
+}
+
+func TestQuotaFor(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     RateLimitConfig
+		wantOK  bool
+		wantMax throttled.Rate
+	}{
+		{"perSec", RateLimitConfig{PerSec: 5}, true, throttled.PerSec(5)},
+		{"perMin fallback", RateLimitConfig{PerMin: 5}, true, throttled.PerMin(5)},
+		{"perHour fallback", RateLimitConfig{PerHour: 5}, true, throttled.PerHour(5)},
+		{"all zero disables", RateLimitConfig{}, false, throttled.Rate{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			quota, ok := quotaFor(c.cfg)
+			if ok != c.wantOK {
+				t.Fatalf("quotaFor(%+v) ok = %v, want %v", c.cfg, ok, c.wantOK)
+			}
+			if ok && quota.MaxRate != c.wantMax {
+				t.Fatalf("quotaFor(%+v) MaxRate = %+v, want %+v", c.cfg, quota.MaxRate, c.wantMax)
+			}
+		})
+	}
+}
+
+func TestOverrideConfig(t *testing.T) {
+	base := RateLimitConfig{PerSec: 2, MaxBurst: 2, VaryByPath: true}
+
+	merged := overrideConfig(base, EndpointRateLimit{Path: "/v1/rotate", PerSec: 10, MaxBurst: 1})
+	if merged.PerSec != 10 || merged.MaxBurst != 1 {
+		t.Fatalf("overrideConfig = %+v, want PerSec=10 MaxBurst=1", merged)
+	}
+	if !merged.VaryByPath {
+		t.Fatalf("overrideConfig should preserve unrelated base fields")
+	}
+
+	unchanged := overrideConfig(base, EndpointRateLimit{Path: "/v1/rotate"})
+	if !reflect.DeepEqual(unchanged, base) {
+		t.Fatalf("overrideConfig(%+v, zero-value override) = %+v, want base unchanged", base, unchanged)
+	}
+}
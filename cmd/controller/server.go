@@ -10,19 +10,29 @@ import (
 	"net/rpc"
 	"time"
 
+	"crypto/tls"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
-	"github.com/throttled/throttled"
-	"github.com/throttled/throttled/store/memstore"
+	"golang.org/x/net/http2"
+	"k8s.io/client-go/kubernetes"
 	certUtil "k8s.io/client-go/util/cert"
+
+	"github.com/saggs-cloudops/sealed-secrets/pkg/httpcache"
 )
 
 var (
-	localAddr    = flag.String("local-addr", ":8081", "trigger rpc serving address.")
-	listenAddr   = flag.String("listen-addr", ":8080", "HTTP serving address.")
-	readTimeout  = flag.Duration("read-timeout", 2*time.Minute, "HTTP request timeout.")
-	writeTimeout = flag.Duration("write-timeout", 2*time.Minute, "HTTP response timeout.")
+	localAddr           = flag.String("local-addr", ":8081", "trigger rpc serving address.")
+	listenAddr          = flag.String("listen-addr", ":8080", "HTTP serving address.")
+	readTimeout         = flag.Duration("read-timeout", 2*time.Minute, "HTTP request timeout.")
+	writeTimeout        = flag.Duration("write-timeout", 2*time.Minute, "HTTP response timeout.")
+	certCacheTTL        = flag.Duration("cert-cache-ttl", 10*time.Minute, "How long to cache /v1/cert.pem and /v1/keyname responses for.")
+	certCacheMaxEntries = flag.Int("cert-cache-max-entries", 1024, "Maximum number of distinct responses to keep in the /v1/cert.pem and /v1/keyname cache.")
 )
 
+// certCache caches /v1/cert.pem and /v1/keyname responses between key rotations. It's set up
+// in httpserver and flushed by keyGenTrigger.Trigger whenever a new key is generated.
+var certCache *httpcache.Cache
+
 // Called on every request to /cert.  Errors will be logged and return a 500.
 type certProvider func(keyname string) ([]*x509.Certificate, error)
 type certNameProvider func() (string, error)
@@ -34,16 +44,94 @@ type blacklistFunc func(string) (bool, error)
 type keyGenTrigger func()
 
 func (b blacklistFunc) Blacklist(keyname string, generated *bool) error {
+	return b.blacklist(keyname, newRequestID(), generated)
+}
+
+// BlacklistWithRequestID behaves like Blacklist but takes a BlacklistArgs, which additionally
+// carries a caller-supplied request ID, so a kubeseal-side ID can be correlated with the controller-side
+// log line for this call. It's a separate method rather than a change to Blacklist's signature
+// so existing callers of Blacklist keep working unmodified.
+func (b blacklistFunc) BlacklistWithRequestID(args BlacklistArgs, generated *bool) error {
+	requestID := args.RequestID
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	return b.blacklist(args.Keyname, requestID, generated)
+}
+
+func (b blacklistFunc) blacklist(keyname, requestID string, generated *bool) error {
+	start := time.Now()
 	gen, err := b(keyname)
 	*generated = gen
+	duration := time.Since(start)
+
+	rpcRequestsTotal.WithLabelValues("Blacklist").Inc()
+	rpcRequestDuration.WithLabelValues("Blacklist").Observe(duration.Seconds())
+	requestLogger.Info("rpc call",
+		"requestID", requestID,
+		"method", "Blacklist",
+		"keyname", keyname,
+		"durationSeconds", duration.Seconds(),
+		"error", errString(err),
+	)
 	return err
 }
 
-func (t keyGenTrigger) Trigger(struct{}, *struct{}) error {
+func (t keyGenTrigger) Trigger(_ struct{}, _ *struct{}) error {
+	return t.trigger(newRequestID())
+}
+
+// TriggerWithRequestID behaves like Trigger but takes a TriggerArgs, which additionally
+// carries a caller-supplied request ID, so a kubeseal-side ID can be correlated with the controller-side
+// log line for this call. It's a separate method rather than a change to Trigger's signature
+// so existing callers of Trigger keep working unmodified.
+func (t keyGenTrigger) TriggerWithRequestID(args TriggerArgs, _ *struct{}) error {
+	requestID := args.RequestID
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	return t.trigger(requestID)
+}
+
+func (t keyGenTrigger) trigger(requestID string) error {
+	start := time.Now()
 	t()
+	if certCache != nil {
+		certCache.Flush()
+	}
+	duration := time.Since(start)
+
+	rpcRequestsTotal.WithLabelValues("Trigger").Inc()
+	rpcRequestDuration.WithLabelValues("Trigger").Observe(duration.Seconds())
+	requestLogger.Info("rpc call",
+		"requestID", requestID,
+		"method", "Trigger",
+		"durationSeconds", duration.Seconds(),
+	)
 	return nil
 }
 
+// BlacklistArgs is the RPC argument struct for blacklister.BlacklistWithRequestID.
+type BlacklistArgs struct {
+	Keyname   string
+	RequestID string
+}
+
+// TriggerArgs is the RPC argument struct for trigger.TriggerWithRequestID. RequestID is
+// optional; when empty the server mints one so the call can still be correlated in its own
+// log line.
+type TriggerArgs struct {
+	RequestID string
+}
+
+// errString renders err for structured logging, returning "" rather than "<nil>" when err is nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
 func adminserver(bl blacklistFunc, kg keyGenTrigger) (func() error, error) {
 	lis, err := net.Listen("tcp", *localAddr)
 	if err != nil {
@@ -56,8 +144,25 @@ func adminserver(bl blacklistFunc, kg keyGenTrigger) (func() error, error) {
 	return lis.Close, nil
 }
 
-func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secretRotator) {
-	httpRateLimiter := rateLimter()
+func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secretRotator, kubeClient kubernetes.Interface, namespace string) {
+	rlCfg, err := loadRateLimitConfig()
+	if err != nil {
+		log.Fatalf("Error loading rate limit config: %v", err)
+	}
+	limiter, limiterOverrides, err := NewRateLimiter(rlCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rateLimit := func(h http.Handler) http.Handler {
+		return rateLimitMiddleware(rlCfg, limiter, limiterOverrides, h)
+	}
+
+	inFlight, err := newMaxInFlightLimiter(*maxRequestsInFlight, *longRunningRequestRE)
+	if err != nil {
+		log.Fatalf("Error compiling --long-running-request-re: %v", err)
+	}
+
+	certCache = httpcache.New(*certCacheTTL, *certCacheMaxEntries)
 
 	mux := http.NewServeMux()
 
@@ -66,11 +171,11 @@ func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secr
 		io.WriteString(w, "ok\n")
 	})
 
-	mux.Handle("/v1/verify", httpRateLimiter.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/v1/verify", withMetrics("/v1/verify", rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		content, err := ioutil.ReadAll(r.Body)
 
 		if err != nil {
-			log.Printf("Error handling /v1/verify request: %v", err)
+			requestLogger.Error("error handling /v1/verify request", "requestID", requestIDFromContext(r.Context()), "error", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -78,23 +183,26 @@ func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secr
 		valid, err := sc(content)
 
 		if err != nil {
-			log.Printf("Error validating secret: %v", err)
+			requestLogger.Error("error validating secret", "requestID", requestIDFromContext(r.Context()), "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
 		if valid {
+			verifyValidTotal.Inc()
 			w.WriteHeader(http.StatusOK)
 		} else {
+			verifyInvalidTotal.Inc()
 			w.WriteHeader(http.StatusConflict)
 		}
-	})))
+	}))))
 
-	mux.HandleFunc("/v1/rotate", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/v1/rotate", withMetrics("/v1/rotate", rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		content, err := ioutil.ReadAll(r.Body)
 
 		if err != nil {
-			log.Printf("Error handling /v1/rotate request: %v", err)
+			rotateErrorsTotal.Inc()
+			requestLogger.Error("error handling /v1/rotate request", "requestID", requestIDFromContext(r.Context()), "error", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -102,7 +210,8 @@ func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secr
 		newSecret, err := sr(content)
 
 		if err != nil {
-			log.Printf("Error rotating secret: %v", err)
+			rotateErrorsTotal.Inc()
+			requestLogger.Error("error rotating secret", "requestID", requestIDFromContext(r.Context()), "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -110,9 +219,9 @@ func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secr
 		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(newSecret)
-	})
+	}))))
 
-	mux.HandleFunc("/v1/cert.pem", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/v1/cert.pem", withMetrics("/v1/cert.pem", rateLimit(certCache.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		keyname := r.URL.Query().Get("keyname")
 		if keyname == "" {
 			keyname, _ = cnp()
@@ -120,7 +229,7 @@ func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secr
 		certs, err := cp(keyname)
 
 		if err != nil {
-			log.Printf("Error handling /cert request: %v", err)
+			requestLogger.Error("error handling /cert request", "requestID", requestIDFromContext(r.Context()), "error", err)
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, "Internal error\n")
@@ -128,51 +237,69 @@ func httpserver(cp certProvider, cnp certNameProvider, sc secretChecker, sr secr
 		}
 
 		w.Header().Set("Content-Type", "application/x-pem-file")
+		var served int
 		for _, cert := range certs {
-			w.Write(certUtil.EncodeCertPEM(cert))
+			n, _ := w.Write(certUtil.EncodeCertPEM(cert))
+			served += n
 		}
-	})
+		certsServedBytes.WithLabelValues(keyname).Set(float64(served))
+	})))))
 
-	mux.HandleFunc("/v1/keyname", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/v1/keyname", withMetrics("/v1/keyname", rateLimit(certCache.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		keyname, err := cnp()
 		if err != nil {
-			log.Printf("Error handling /cert request: %v", err)
+			requestLogger.Error("error handling /cert request", "requestID", requestIDFromContext(r.Context()), "error", err)
 			w.Header().Set("Content-Type", "text/plain;charset=utf-8")
 			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, "Internal error\n")
 			return
 		}
 
+		setActiveKeyname(keyname)
 		w.Header().Set("Content-Type", "text/plain;charset=utf-8")
 		io.WriteString(w, keyname)
-	})
+	})))))
+
+	if *metricsListenAddr == "" || *metricsListenAddr == *listenAddr {
+		mux.Handle("/metrics", promhttp.Handler())
+	} else {
+		go serveMetrics(*metricsListenAddr)
+	}
 
 	server := http.Server{
 		Addr:         *listenAddr,
-		Handler:      mux,
+		Handler:      withRequestLogging(inFlight.WithMaxInFlight(mux)),
 		ReadTimeout:  *readTimeout,
 		WriteTimeout: *writeTimeout,
 	}
 
-	log.Printf("HTTP server serving on %s", server.Addr)
-	err := server.ListenAndServe()
-	log.Printf("HTTP server exiting: %v", err)
-}
+	if !*enableTLS {
+		log.Printf("HTTP server serving on %s", server.Addr)
+		err = server.ListenAndServe()
+		log.Printf("HTTP server exiting: %v", err)
+		return
+	}
 
-func rateLimter() throttled.HTTPRateLimiter {
-	store, err := memstore.New(65536)
+	cert, err := loadOrGenerateTLSCert(kubeClient, namespace)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Error loading TLS certificate: %v", err)
 	}
-
-	quota := throttled.RateQuota{MaxRate: throttled.PerSec(2), MaxBurst: 2}
-	rateLimiter, err := throttled.NewGCRARateLimiter(store, quota)
+	tlsConfig, err := newTLSConfig(cert)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Error building TLS config: %v", err)
 	}
-	return throttled.HTTPRateLimiter{
-		RateLimiter: rateLimiter,
-		VaryBy:      &throttled.VaryBy{Path: true, Headers: []string{"X-Forwarded-For"}},
+	server.TLSConfig = tlsConfig
+
+	if *enableHTTP2 {
+		if err := http2.ConfigureServer(&server, &http2.Server{}); err != nil {
+			log.Fatalf("Error configuring HTTP/2: %v", err)
+		}
+	} else {
+		// A non-nil, empty TLSNextProto keeps net/http from enabling HTTP/2 on its own.
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 	}
 
+	log.Printf("HTTP server serving on %s", server.Addr)
+	err = server.ListenAndServeTLS("", "")
+	log.Printf("HTTP server exiting: %v", err)
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	enableTLS             = flag.Bool("enable-tls", false, "Serve the HTTP API over TLS instead of plain HTTP. When false, all other --tls-* flags are ignored.")
+	tlsCertFile           = flag.String("tls-cert-file", "", "Path to a TLS certificate for the HTTP server. If unset along with --tls-key-file, a self-signed certificate is generated on first boot and persisted in --tls-secret-name.")
+	tlsKeyFile            = flag.String("tls-key-file", "", "Path to the private key matching --tls-cert-file.")
+	tlsMinVersion         = flag.String("tls-min-version", "1.2", `Minimum TLS version to accept: "1.2" or "1.3".`)
+	enableHTTP2           = flag.Bool("enable-http2", true, "Serve HTTP/2 in addition to HTTP/1.1 over the TLS listener.")
+	tlsSecretName         = flag.String("tls-secret-name", "sealed-secrets-controller-tls", "Name of the Secret used to persist a generated self-signed certificate.")
+	tlsServiceName        = flag.String("tls-service-name", "sealed-secrets-controller", "Kubernetes Service name to include as a SAN in the generated self-signed certificate.")
+	tlsAdditionalDNSNames = flag.StringSlice("tls-additional-dns-names", nil, "Extra DNS SAN entries to include in the generated self-signed certificate.")
+	tlsAdditionalIPs      = flag.StringSlice("tls-additional-ips", nil, "Extra IP address SAN entries to include in the generated self-signed certificate.")
+)
+
+const (
+	tlsSecretCertKey = "tls.crt"
+	tlsSecretKeyKey  = "tls.key"
+)
+
+// hardenedCipherSuites restricts TLS 1.2 negotiation to the ECDHE-GCM/CHACHA20 suites; it has
+// no effect on TLS 1.3, which only offers AEAD suites.
+var hardenedCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// tlsMinVersionFromFlag maps --tls-min-version to its crypto/tls constant.
+func tlsMinVersionFromFlag() (uint16, error) {
+	switch *tlsMinVersion {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf(`unsupported --tls-min-version %q, must be "1.2" or "1.3"`, *tlsMinVersion)
+	}
+}
+
+// loadOrGenerateTLSCert returns the TLS certificate the HTTP server should use: loaded from
+// --tls-cert-file/--tls-key-file when set, otherwise from the --tls-secret-name Secret,
+// generating and persisting a self-signed certificate there on first boot.
+func loadOrGenerateTLSCert(client kubernetes.Interface, namespace string) (tls.Certificate, error) {
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		return tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+	}
+
+	secrets := client.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(context.Background(), *tlsSecretName, metav1.GetOptions{})
+	if err == nil {
+		return tls.X509KeyPair(secret.Data[tlsSecretCertKey], secret.Data[tlsSecretKeyKey])
+	}
+	if !apierrors.IsNotFound(err) {
+		return tls.Certificate{}, err
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCert(namespace)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	_, err = secrets.Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: *tlsSecretName, Namespace: namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			tlsSecretCertKey: certPEM,
+			tlsSecretKeyKey:  keyPEM,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("persisting generated TLS certificate: %v", err)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// sanEntriesFor returns the DNS names and IP addresses the self-signed certificate's SAN
+// extension should cover: the usual in-cluster Service DNS forms for *tlsServiceName in
+// namespace, plus whatever the operator added via --tls-additional-dns-names/-ips.
+func sanEntriesFor(namespace string) ([]string, []net.IP) {
+	dnsNames := []string{
+		"localhost",
+		*tlsServiceName,
+		fmt.Sprintf("%s.%s", *tlsServiceName, namespace),
+		fmt.Sprintf("%s.%s.svc", *tlsServiceName, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", *tlsServiceName, namespace),
+	}
+	dnsNames = append(dnsNames, *tlsAdditionalDNSNames...)
+
+	ips := []net.IP{net.IPv4(127, 0, 0, 1)}
+	for _, raw := range *tlsAdditionalIPs {
+		if ip := net.ParseIP(raw); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return dnsNames, ips
+}
+
+// generateSelfSignedCert creates a self-signed, non-CA ECDSA leaf certificate valid for one
+// year, covering the SAN entries sanEntriesFor(namespace) returns.
+func generateSelfSignedCert(namespace string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dnsNames, ips := sanEntriesFor(namespace)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: *tlsServiceName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// newTLSConfig builds the hardened tls.Config the HTTP server serves with.
+func newTLSConfig(cert tls.Certificate) (*tls.Config, error) {
+	minVersion, err := tlsMinVersionFromFlag()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: hardenedCipherSuites,
+	}, nil
+}
@@ -0,0 +1,153 @@
+// Package httpcache provides a small in-memory, LRU-bounded HTTP response cache for handlers
+// whose output changes rarely, such as certificate or key name lookups.
+package httpcache
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key        string
+	statusCode int
+	header     http.Header
+	body       []byte
+	expiresAt  time.Time
+}
+
+// Cache is an http.Handler wrapper that caches successful (200) GET responses in memory,
+// keyed by request method and URL, for up to ttl. Requests sent with a Cache-Control: no-cache
+// header bypass the cache. It is safe for concurrent use.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// New returns a Cache that keeps up to maxEntries responses, each for up to ttl. A non-positive
+// maxEntries disables the entry limit.
+func New(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Flush discards every cached response.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// responseRecorder tees a handler's response through to the underlying ResponseWriter while
+// also buffering it for the cache.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.statusCode = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func cacheKey(r *http.Request) string {
+	return r.Method + " " + r.URL.String()
+}
+
+// Wrap returns an http.Handler that serves cached responses when available and otherwise
+// delegates to h, caching its response if it comes back with a 200 status.
+func (c *Cache) Wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Cache-Control") == "no-cache" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		if e, ok := c.get(key); ok {
+			// Headers already set on w by outer handlers (e.g. a per-request ID) take precedence
+			// over the cached ones.
+			for name, values := range e.header {
+				if _, set := w.Header()[name]; set {
+					continue
+				}
+				w.Header()[name] = values
+			}
+			w.WriteHeader(e.statusCode)
+			w.Write(e.body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		h.ServeHTTP(rec, r)
+
+		if rec.statusCode == http.StatusOK {
+			c.put(key, rec.statusCode, w.Header().Clone(), rec.body.Bytes())
+		}
+	})
+}
+
+func (c *Cache) get(key string) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return e, true
+}
+
+func (c *Cache) put(key string, statusCode int, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+
+	el := c.order.PushFront(&entry{
+		key:        key,
+		statusCode: statusCode,
+		header:     header,
+		body:       body,
+		expiresAt:  time.Now().Add(c.ttl),
+	})
+	c.entries[key] = el
+
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+}
@@ -0,0 +1,142 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheServesCachedResponse(t *testing.T) {
+	var calls int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("hello"))
+	})
+
+	c := New(time.Minute, 0)
+	wrapped := c.Wrap(h)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/keyname", nil)
+
+	rec1 := httptest.NewRecorder()
+	wrapped.ServeHTTP(rec1, r)
+	rec2 := httptest.NewRecorder()
+	wrapped.ServeHTTP(rec2, r)
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1 (second request should be served from cache)", calls)
+	}
+	if rec2.Body.String() != "hello" {
+		t.Fatalf("cached body = %q, want %q", rec2.Body.String(), "hello")
+	}
+	if ct := rec2.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Fatalf("cached Content-Type = %q, want %q", ct, "text/plain")
+	}
+}
+
+func TestCacheExpiresAfterTTL(t *testing.T) {
+	var calls int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	c := New(time.Millisecond, 0)
+	wrapped := c.Wrap(h)
+	r := httptest.NewRequest(http.MethodGet, "/v1/keyname", nil)
+
+	wrapped.ServeHTTP(httptest.NewRecorder(), r)
+	time.Sleep(5 * time.Millisecond)
+	wrapped.ServeHTTP(httptest.NewRecorder(), r)
+
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want 2 (entry should have expired)", calls)
+	}
+}
+
+func TestCacheBypassesOnNoCacheHeader(t *testing.T) {
+	var calls int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	c := New(time.Minute, 0)
+	wrapped := c.Wrap(h)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/keyname", nil)
+	r.Header.Set("Cache-Control", "no-cache")
+
+	wrapped.ServeHTTP(httptest.NewRecorder(), r)
+	wrapped.ServeHTTP(httptest.NewRecorder(), r)
+
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want 2 (no-cache should always bypass)", calls)
+	}
+}
+
+func TestCacheDoesNotCacheNonOKResponses(t *testing.T) {
+	var calls int
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	c := New(time.Minute, 0)
+	wrapped := c.Wrap(h)
+	r := httptest.NewRequest(http.MethodGet, "/v1/keyname", nil)
+
+	wrapped.ServeHTTP(httptest.NewRecorder(), r)
+	wrapped.ServeHTTP(httptest.NewRecorder(), r)
+
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want 2 (5xx responses should not be cached)", calls)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsedOverMaxEntries(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	c := New(time.Minute, 2)
+	wrapped := c.Wrap(h)
+
+	get := func(path string) {
+		wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	get("/a")
+	get("/b")
+	get("/c") // evicts /a, the least recently used entry
+
+	if _, ok := c.get(cacheKey(httptest.NewRequest(http.MethodGet, "/a", nil))); ok {
+		t.Fatalf("/a should have been evicted once the cache exceeded maxEntries")
+	}
+	if _, ok := c.get(cacheKey(httptest.NewRequest(http.MethodGet, "/b", nil))); !ok {
+		t.Fatalf("/b should still be cached")
+	}
+	if _, ok := c.get(cacheKey(httptest.NewRequest(http.MethodGet, "/c", nil))); !ok {
+		t.Fatalf("/c should still be cached")
+	}
+}
+
+func TestFlushDiscardsEntries(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	c := New(time.Minute, 0)
+	wrapped := c.Wrap(h)
+	r := httptest.NewRequest(http.MethodGet, "/v1/keyname", nil)
+
+	wrapped.ServeHTTP(httptest.NewRecorder(), r)
+	c.Flush()
+
+	if _, ok := c.get(cacheKey(r)); ok {
+		t.Fatalf("entry should not survive Flush")
+	}
+}